@@ -0,0 +1,100 @@
+package highlighter
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+)
+
+// defaultCacheSize is the number of unique lines kept in the rendered-line
+// cache.  Log tails tend to repeat a fairly small set of distinct lines
+// (the same format string over and over), so this doesn't need to be huge.
+const defaultCacheSize = 4096
+
+// lineCache memoizes the fully-rendered, colored bytes for each unique
+// input line.  The cache key is just the raw line content: the invariant
+// that makes this safe is that rules are immutable once argument parsing
+// is done, so a single ruleHash (computed once at startup) identifies the
+// ruleset for the whole run, and is only used to label the cache for
+// -cachestats rather than as part of the per-entry key.
+type lineCache struct {
+	ruleHash string
+	maxSize  int
+
+	entries map[string]*list.Element
+	order   *list.List // front = most recently used
+
+	hits, misses int
+}
+
+type cacheEntry struct {
+	key      string
+	rendered []byte
+}
+
+// newLineCache creates a line cache bounded to maxSize entries.
+func newLineCache(ruleHash string, maxSize int) *lineCache {
+	if maxSize <= 0 {
+		maxSize = defaultCacheSize
+	}
+	return &lineCache{
+		ruleHash: ruleHash,
+		maxSize:  maxSize,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+// Get returns the cached rendered bytes for content, if any.
+func (c *lineCache) Get(content []byte) ([]byte, bool) {
+	key := string(content)
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).rendered, true
+}
+
+// Put stores rendered as the rendering for content, evicting the least
+// recently used entry if the cache is full.
+func (c *lineCache) Put(content, rendered []byte) {
+	key := string(content)
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cacheEntry).rendered = rendered
+		c.order.MoveToFront(elem)
+		return
+	}
+	elem := c.order.PushFront(&cacheEntry{key: key, rendered: rendered})
+	c.entries[key] = elem
+	if c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Stats returns a human-readable summary of cache hit/miss counts, for
+// the --cachestats debug flag.
+func (c *lineCache) Stats() string {
+	total := c.hits + c.misses
+	rate := 0.0
+	if total > 0 {
+		rate = 100 * float64(c.hits) / float64(total)
+	}
+	return fmt.Sprintf("highlight: cache %s: %d hits, %d misses (%.1f%% hit rate), %d/%d entries",
+		c.ruleHash, c.hits, c.misses, rate, len(c.entries), c.maxSize)
+}
+
+// hashRules computes a stable identifier for a set of word/line rules, so
+// that CacheStats output can be tied to the ruleset that produced it.
+// It's not used as part of the cache key itself: the cache only lives as
+// long as a single Highlighter, whose rules never change once built.
+func hashRules(cfg Config) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%+v%+v%+v%s", cfg.WordRules, cfg.LineRules, cfg.TokenRules, cfg.DefaultColor)
+	return hex.EncodeToString(h.Sum(nil))[:8]
+}
@@ -0,0 +1,223 @@
+package highlighter
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/augustoroman/ansi"
+)
+
+// ParseColor parses a color spec of the form "FG[+mod][:BG[+mod]]", the
+// same grammar ansi.ColorCode accepts, but additionally understands
+// truecolor forms for FG and/or BG:
+//
+//	#rrggbb        e.g. #ff8800
+//	rgb(r,g,b)     e.g. rgb(255,136,0)
+//	a CSS color name, e.g. "orange", "slategray"
+//
+// When the terminal advertises truecolor support ($COLORTERM=truecolor or
+// 24bit), these emit 24-bit ESC[38;2;…m / ESC[48;2;…m sequences.
+// Otherwise they're quantized to the nearest xterm-256 palette index.
+// Anything not in truecolor form is delegated to ansi.ColorCode
+// unchanged, so plain names and palette numbers behave exactly as before.
+func ParseColor(spec string) string {
+	fg, bg := spec, ""
+	if idx := strings.IndexByte(spec, ':'); idx >= 0 {
+		fg, bg = spec[:idx], spec[idx+1:]
+	}
+
+	fgRGB, fgMods, fgIsTrue := parseColorPart(fg)
+	bgRGB, bgMods, bgIsTrue := parseColorPart(bg)
+	if !fgIsTrue && !bgIsTrue {
+		return ansi.ColorCode(spec) // nothing truecolor-shaped; unchanged behavior
+	}
+
+	var out strings.Builder
+	// A named (non-truecolor) background is emitted via ansi.ColorCode(":bg"),
+	// which -- since it carries an empty FG slot -- resets to a default
+	// foreground as part of setting the background. Write it first so that
+	// it doesn't clobber a truecolor/named FG written afterwards.
+	if bg != "" && !bgIsTrue {
+		out.WriteString(ansi.ColorCode(":" + bg))
+	}
+	if fgIsTrue {
+		out.WriteString(rgbEscape(fgRGB, false))
+	} else if fg != "" {
+		out.WriteString(ansi.ColorCode(fg))
+	}
+	if bgIsTrue {
+		out.WriteString(rgbEscape(bgRGB, true))
+	}
+	out.WriteString(modifierEscape(fgMods + bgMods))
+	return out.String()
+}
+
+type rgb struct{ r, g, b byte }
+
+var (
+	reHexColor = regexp.MustCompile(`^#([0-9a-fA-F]{6})$`)
+	reRGBColor = regexp.MustCompile(`^rgb\(\s*(\d+)\s*,\s*(\d+)\s*,\s*(\d+)\s*\)$`)
+)
+
+// parseColorPart splits "color+mods" into its RGB value (if truecolor),
+// its modifier letters, and whether it was truecolor-shaped at all.
+func parseColorPart(part string) (rgb, string, bool) {
+	if part == "" {
+		return rgb{}, "", false
+	}
+	name, mods := part, ""
+	if i := strings.IndexByte(part, '+'); i >= 0 {
+		name, mods = part[:i], part[i+1:]
+	}
+	if m := reHexColor.FindStringSubmatch(name); m != nil {
+		v, _ := strconv.ParseUint(m[1], 16, 32)
+		return rgb{byte(v >> 16), byte(v >> 8), byte(v)}, mods, true
+	}
+	if m := reRGBColor.FindStringSubmatch(name); m != nil {
+		r, _ := strconv.Atoi(m[1])
+		g, _ := strconv.Atoi(m[2])
+		b, _ := strconv.Atoi(m[3])
+		return rgb{byte(r), byte(g), byte(b)}, mods, true
+	}
+	if c, ok := cssColors[strings.ToLower(name)]; ok {
+		return c, mods, true
+	}
+	return rgb{}, "", false
+}
+
+// rgbEscape emits either a 24-bit truecolor escape sequence or, when the
+// terminal doesn't advertise truecolor support, the nearest xterm-256
+// palette index.
+func rgbEscape(c rgb, background bool) string {
+	kind := 38
+	if background {
+		kind = 48
+	}
+	if TrueColorSupported() {
+		return fmt.Sprintf("\x1b[%d;2;%d;%d;%dm", kind, c.r, c.g, c.b)
+	}
+	return fmt.Sprintf("\x1b[%d;5;%dm", kind, nearestXterm256(c))
+}
+
+// modifierCodes maps the single-letter modifiers documented in `usage`
+// (d/h/b/u/i/s/B) to their SGR codes.  "h" (high-intensity) has no SGR
+// equivalent once a color has already been set via 38;2/38;5, so it's
+// simply ignored for truecolor/256 colors -- they already cover the full
+// brightness range.
+var modifierCodes = map[byte]int{
+	'd': 2, 'b': 1, 'u': 4, 'i': 7, 's': 9, 'B': 5,
+}
+
+func modifierEscape(mods string) string {
+	var out strings.Builder
+	for i := 0; i < len(mods); i++ {
+		if code, ok := modifierCodes[mods[i]]; ok {
+			fmt.Fprintf(&out, "\x1b[%dm", code)
+		}
+	}
+	return out.String()
+}
+
+// TrueColorSupported reports whether the terminal advertises 24-bit color
+// support via $COLORTERM.
+func TrueColorSupported() bool {
+	switch os.Getenv("COLORTERM") {
+	case "truecolor", "24bit":
+		return true
+	}
+	return false
+}
+
+// nearestXterm256 quantizes an RGB triple to the closest color in the
+// standard xterm 256-color palette: a 6x6x6 color cube (indices 16-231)
+// plus a 24-step grayscale ramp (indices 232-255).
+func nearestXterm256(c rgb) int {
+	toCubeIdx := func(v byte) int {
+		// Cube steps are at 0, 95, 135, 175, 215, 255.
+		steps := [6]int{0, 95, 135, 175, 215, 255}
+		best, bestDiff := 0, 256
+		for i, s := range steps {
+			if diff := abs(int(v) - s); diff < bestDiff {
+				best, bestDiff = i, diff
+			}
+		}
+		return best
+	}
+	ri, gi, bi := toCubeIdx(c.r), toCubeIdx(c.g), toCubeIdx(c.b)
+	cubeSteps := [6]int{0, 95, 135, 175, 215, 255}
+	cubeIdx := 16 + 36*ri + 6*gi + bi
+	cubeDist := sq(int(c.r)-cubeSteps[ri]) + sq(int(c.g)-cubeSteps[gi]) + sq(int(c.b)-cubeSteps[bi])
+
+	gray := (int(c.r) + int(c.g) + int(c.b)) / 3
+	grayIdx := (gray - 3) / 10 // round to the nearest level 8+10*i
+	if grayIdx < 0 {
+		grayIdx = 0
+	}
+	if grayIdx > 23 {
+		grayIdx = 23
+	}
+	grayLevel := 8 + grayIdx*10
+	grayDist := sq(int(c.r)-grayLevel) + sq(int(c.g)-grayLevel) + sq(int(c.b)-grayLevel)
+
+	if grayDist < cubeDist {
+		return 232 + grayIdx
+	}
+	return cubeIdx
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func sq(x int) int { return x * x }
+
+// cssColors is a modest set of common CSS/X11 color names, not the full
+// 148-name spec -- enough to cover the colors people actually reach for.
+var cssColors = map[string]rgb{
+	"orange":     {255, 165, 0},
+	"purple":     {128, 0, 128},
+	"pink":       {255, 192, 203},
+	"brown":      {165, 42, 42},
+	"gray":       {128, 128, 128},
+	"grey":       {128, 128, 128},
+	"navy":       {0, 0, 128},
+	"teal":       {0, 128, 128},
+	"lime":       {0, 255, 0},
+	"maroon":     {128, 0, 0},
+	"olive":      {128, 128, 0},
+	"silver":     {192, 192, 192},
+	"gold":       {255, 215, 0},
+	"violet":     {238, 130, 238},
+	"indigo":     {75, 0, 130},
+	"coral":      {255, 127, 80},
+	"salmon":     {250, 128, 114},
+	"khaki":      {240, 230, 140},
+	"crimson":    {220, 20, 60},
+	"turquoise":  {64, 224, 208},
+	"chocolate":  {210, 105, 30},
+	"orchid":     {218, 112, 214},
+	"plum":       {221, 160, 221},
+	"slategray":  {112, 128, 144},
+	"slategrey":  {112, 128, 144},
+	"tan":        {210, 180, 140},
+	"beige":      {245, 245, 220},
+	"ivory":      {255, 255, 240},
+	"lavender":   {230, 230, 250},
+	"chartreuse": {127, 255, 0},
+	"skyblue":    {135, 206, 235},
+	"steelblue":  {70, 130, 180},
+	"tomato":     {255, 99, 71},
+	"orangered":  {255, 69, 0},
+	"hotpink":    {255, 105, 180},
+	"firebrick":  {178, 34, 34},
+	"forestgreen": {34, 139, 34},
+	"seagreen":   {46, 139, 87},
+	"royalblue":  {65, 105, 225},
+	"midnightblue": {25, 25, 112},
+}
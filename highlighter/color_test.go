@@ -0,0 +1,31 @@
+package highlighter
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/augustoroman/ansi"
+)
+
+func TestParseColorTruecolorFGWithNamedBG(t *testing.T) {
+	old := os.Getenv("COLORTERM")
+	os.Setenv("COLORTERM", "truecolor")
+	defer os.Setenv("COLORTERM", old)
+
+	got := ParseColor("#ff8800:white")
+
+	// The named background is emitted first (it carries a default-FG reset
+	// of its own), and the truecolor foreground is written after, so it's
+	// the last thing applied rather than being clobbered by that reset.
+	fgCode := rgbEscape(rgb{0xff, 0x88, 0x00}, false)
+	if !strings.HasSuffix(got, fgCode) {
+		t.Errorf("ParseColor(%q) = %q, want it to end with the truecolor FG escape %q",
+			"#ff8800:white", got, fgCode)
+	}
+
+	want := ansi.ColorCode(":white") + fgCode
+	if got != want {
+		t.Errorf("ParseColor(%q) = %q, want %q", "#ff8800:white", got, want)
+	}
+}
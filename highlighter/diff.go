@@ -0,0 +1,281 @@
+package highlighter
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+
+	"github.com/augustoroman/ansi"
+)
+
+// DiffLineType classifies a single line of a unified diff.
+type DiffLineType int
+
+const (
+	DiffOther DiffLineType = iota
+	DiffFileHeader
+	DiffHunkHeader
+	DiffAdded
+	DiffRemoved
+	DiffContext
+)
+
+var (
+	reDiffFileHeader = regexp.MustCompile(`^(--- |\+\+\+ )`)
+	reDiffHunkHeader = regexp.MustCompile(`^@@ -\d+(,\d+)? \+\d+(,\d+)? @@`)
+)
+
+// DiffClassifier tracks whether we're inside a hunk, since '+'/'-' only
+// mean "added"/"removed" once a hunk header has been seen; a bare '+' or
+// '-' before that (e.g. in a commit message) is just regular text.
+type DiffClassifier struct {
+	inHunk bool
+}
+
+// Classify returns the DiffLineType of line and updates the classifier's
+// internal state.
+func (d *DiffClassifier) Classify(line []byte) DiffLineType {
+	switch {
+	case reDiffFileHeader.Match(line):
+		d.inHunk = false
+		return DiffFileHeader
+	case reDiffHunkHeader.Match(line):
+		d.inHunk = true
+		return DiffHunkHeader
+	case !d.inHunk:
+		return DiffOther
+	case bytes.HasPrefix(line, []byte("+")):
+		return DiffAdded
+	case bytes.HasPrefix(line, []byte("-")):
+		return DiffRemoved
+	default:
+		return DiffContext
+	}
+}
+
+// DiffConfig holds the colors and state needed to run highlight in -diff
+// mode.  Unlike WordRule/LineRule, diff classification is stateful across
+// lines, so it lives on the Highlighter rather than as one more Rule.
+type DiffConfig struct {
+	Classifier DiffClassifier
+
+	FileHeaderColor string
+	HunkColor       string
+	AddColor        string
+	DelColor        string
+	AddWordColor    string
+	DelWordColor    string
+
+	// pending holds a buffered '-' line (with its leading '-' but without
+	// its trailing newline) waiting to see if the next line is a matching
+	// '+', so the pair can be intra-line word-diffed.
+	pending      []byte
+	pendingHasNL bool
+}
+
+// WriteDiffLine classifies and colorizes a single line in -diff mode.  It
+// may buffer a '-' line rather than writing it immediately, so callers
+// must call Close (see Highlighter.Close) once the input is exhausted.
+func (c *Highlighter) WriteDiffLine(line []byte) (int, error) {
+	N := len(line)
+	hasNewline := bytes.HasSuffix(line, []byte("\n"))
+	content := bytes.TrimSuffix(line, []byte("\n"))
+
+	cls := c.diff.Classifier.Classify(content)
+
+	if cls == DiffRemoved {
+		if err := c.flushPending(); err != nil {
+			return 0, err
+		}
+		c.diff.pending = append([]byte{}, content...)
+		c.diff.pendingHasNL = hasNewline
+		return N, nil
+	}
+
+	if cls == DiffAdded && c.diff.pending != nil {
+		removed, added := c.diff.pending, content
+		remHadNL, thisHasNL := c.diff.pendingHasNL, hasNewline
+		c.diff.pending = nil
+
+		delSpans, addSpans := WordDiff(removed, added)
+		if _, err := c.writeColoredSpans(removed, c.diff.DelColor, c.diff.DelWordColor, delSpans, remHadNL); err != nil {
+			return 0, err
+		}
+		if _, err := c.writeColoredSpans(added, c.diff.AddColor, c.diff.AddWordColor, addSpans, thisHasNL); err != nil {
+			return 0, err
+		}
+		return N, nil
+	}
+
+	if err := c.flushPending(); err != nil {
+		return 0, err
+	}
+
+	color := c.diffColorFor(cls)
+	return c.writeColoredLine(content, color, hasNewline)
+}
+
+func (c *Highlighter) diffColorFor(cls DiffLineType) string {
+	switch cls {
+	case DiffFileHeader:
+		return c.diff.FileHeaderColor
+	case DiffHunkHeader:
+		return c.diff.HunkColor
+	case DiffAdded:
+		return c.diff.AddColor
+	case DiffRemoved:
+		return c.diff.DelColor
+	default:
+		return c.defaultColor
+	}
+}
+
+// flushPending writes out a buffered '-' line plainly, for when it turns
+// out not to be followed by a matching '+' line.
+func (c *Highlighter) flushPending() error {
+	if c.diff.pending == nil {
+		return nil
+	}
+	line, hasNL := c.diff.pending, c.diff.pendingHasNL
+	c.diff.pending = nil
+	_, err := c.writeColoredLine(line, c.diff.DelColor, hasNL)
+	return err
+}
+
+func (c *Highlighter) writeColoredLine(line []byte, color string, hasNewline bool) (int, error) {
+	return c.writeColoredSpans(line, color, "", nil, hasNewline)
+}
+
+// writeColoredSpans writes line in color, with the byte ranges in spans
+// additionally highlighted in wordColor.
+func (c *Highlighter) writeColoredSpans(line []byte, color, wordColor string, spans [][2]int, hasNewline bool) (int, error) {
+	var buf bytes.Buffer
+	if color != "" {
+		buf.WriteString(color)
+	}
+	cur := 0
+	for _, span := range spans {
+		buf.Write(line[cur:span[0]])
+		buf.WriteString(wordColor)
+		buf.Write(line[span[0]:span[1]])
+		if color != "" {
+			buf.WriteString(color)
+		} else {
+			buf.WriteString(ansi.Reset)
+		}
+		cur = span[1]
+	}
+	buf.Write(line[cur:])
+	if color != "" || len(spans) > 0 {
+		buf.WriteString(ansi.Reset)
+	}
+	if hasNewline {
+		buf.WriteByte('\n')
+	}
+	return io.WriteString(c.out, buf.String())
+}
+
+// wordSplit tokenizes a line into words and the whitespace between them,
+// so that LCS-based diffing operates on whole words instead of bytes.
+var wordSplit = regexp.MustCompile(`\S+|\s+`)
+
+// WordDiff computes a word-level diff between two lines (typically an
+// adjacent removed/added pair in a diff hunk) using an LCS over word
+// tokens.  It returns the byte spans, within each line respectively, that
+// are NOT part of the longest common subsequence -- i.e. the
+// removed/added words to highlight.
+//
+// removed and added are expected to carry their unified-diff marker
+// ('-'/'+') in column 0; that column is excluded from both tokenizing and
+// the returned spans, so an unchanged leading word isn't flagged as
+// changed just because the two lines' markers differ.
+func WordDiff(removed, added []byte) (delSpans, addSpans [][2]int) {
+	remBody, remOffset := stripMarker(removed)
+	addBody, addOffset := stripMarker(added)
+
+	aTokens := tokenSpans(remBody)
+	bTokens := tokenSpans(addBody)
+	aWords := tokenStrings(remBody, aTokens)
+	bWords := tokenStrings(addBody, bTokens)
+
+	common := lcsMask(aWords, bWords)
+
+	for i, tok := range aTokens {
+		if !common.a[i] && isWord(aWords[i]) {
+			delSpans = append(delSpans, [2]int{tok[0] + remOffset, tok[1] + remOffset})
+		}
+	}
+	for i, tok := range bTokens {
+		if !common.b[i] && isWord(bWords[i]) {
+			addSpans = append(addSpans, [2]int{tok[0] + addOffset, tok[1] + addOffset})
+		}
+	}
+	return delSpans, addSpans
+}
+
+// stripMarker removes a leading unified-diff '-'/'+' marker, if present,
+// returning the remaining bytes and how many bytes were removed.
+func stripMarker(line []byte) ([]byte, int) {
+	if len(line) > 0 && (line[0] == '-' || line[0] == '+') {
+		return line[1:], 1
+	}
+	return line, 0
+}
+
+func isWord(s string) bool { return len(s) > 0 && s[0] != ' ' && s[0] != '\t' }
+
+func tokenSpans(line []byte) [][2]int {
+	idxs := wordSplit.FindAllIndex(line, -1)
+	spans := make([][2]int, len(idxs))
+	for i, idx := range idxs {
+		spans[i] = [2]int{idx[0], idx[1]}
+	}
+	return spans
+}
+
+func tokenStrings(line []byte, spans [][2]int) []string {
+	words := make([]string, len(spans))
+	for i, s := range spans {
+		words[i] = string(line[s[0]:s[1]])
+	}
+	return words
+}
+
+type lcsResult struct{ a, b []bool }
+
+// lcsMask marks, for each token in a and b, whether it participates in
+// the longest common subsequence of the two token sequences.
+func lcsMask(a, b []string) lcsResult {
+	n, m := len(a), len(b)
+	dp := make([][]int, n+1)
+	for i := range dp {
+		dp[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else if dp[i+1][j] >= dp[i][j+1] {
+				dp[i][j] = dp[i+1][j]
+			} else {
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+
+	res := lcsResult{a: make([]bool, n), b: make([]bool, m)}
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			res.a[i], res.b[j] = true, true
+			i++
+			j++
+		case dp[i+1][j] >= dp[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return res
+}
@@ -0,0 +1,310 @@
+package highlighter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/augustoroman/ansi"
+)
+
+// Config describes a complete set of highlighting rules: everything
+// needed to build a Highlighter.  It can be assembled by hand, built up
+// incrementally via AddRule as a CLI parses its flags, or populated from
+// a parsed Profile (see LoadProfile).
+type Config struct {
+	DefaultColor string
+	WordRules    []WordRule
+	LineRules    []LineRule
+	TokenRules   []TokenRule
+	Diff         *DiffConfig
+
+	// NoCache disables the per-line rendered-output cache that
+	// NewHighlighter otherwise enables by default.  The cache is never
+	// used when Diff is set.
+	NoCache bool
+}
+
+// AddRule appends a WordRule, LineRule, or TokenRule (passed as *WordRule,
+// *LineRule, or *TokenRule) to the Config. A nil rule is ignored, so
+// callers can track "the rule currently being built" in a single
+// variable and call AddRule(current) unconditionally between rules.
+func (cfg *Config) AddRule(rule interface{}) {
+	if rule == nil {
+		return
+	}
+	switch r := rule.(type) {
+	case *LineRule:
+		cfg.LineRules = append(cfg.LineRules, *r)
+	case *WordRule:
+		cfg.WordRules = append(cfg.WordRules, *r)
+	case *TokenRule:
+		cfg.TokenRules = append(cfg.TokenRules, *r)
+	default:
+		panic(fmt.Sprintf("highlighter: unknown rule type: %T", rule))
+	}
+}
+
+// Highlighter colorizes a stream of text according to a Config: an
+// io.Writer wraps another io.Writer, coloring each line as it's written.
+//
+// Construct one with NewHighlighter; don't build a Highlighter directly.
+type Highlighter struct {
+	defaultColor string
+	wordRules    []WordRule
+	lineRules    []LineRule
+	tokenRules   []TokenRule
+	diff         *DiffConfig
+	cache        *lineCache
+	out          io.Writer
+}
+
+// NewHighlighter returns an io.Writer that colorizes whatever is written
+// to it, according to cfg, and writes the result to out.
+//
+// The returned value also implements io.Closer: callers using -diff mode
+// (cfg.Diff != nil) must Close it once done writing, to flush any
+// buffered diff line waiting to be paired for word-diffing. Closing is a
+// no-op otherwise.
+func NewHighlighter(out io.Writer, cfg Config) io.Writer {
+	h := &Highlighter{
+		defaultColor: cfg.DefaultColor,
+		wordRules:    cfg.WordRules,
+		lineRules:    cfg.LineRules,
+		tokenRules:   cfg.TokenRules,
+		diff:         cfg.Diff,
+		out:          out,
+	}
+	if !cfg.NoCache && cfg.Diff == nil {
+		h.cache = newLineCache(hashRules(cfg), defaultCacheSize)
+	}
+	return h
+}
+
+// Close flushes any buffered diff state. It must be called once the
+// input is exhausted when -diff mode is in use; it's a no-op otherwise.
+func (c *Highlighter) Close() error {
+	if c.diff == nil {
+		return nil
+	}
+	return c.flushPending()
+}
+
+// CacheStats returns a human-readable summary of cache hit/miss counts,
+// or "" if caching is disabled.
+func (c *Highlighter) CacheStats() string {
+	if c.cache == nil {
+		return ""
+	}
+	return c.cache.Stats()
+}
+
+func (c *Highlighter) Write(data []byte) (int, error) {
+	var err error
+	var n, written int
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if c.diff != nil {
+			n, err = c.WriteDiffLine(line)
+		} else {
+			n, err = c.WriteOneLine(line)
+		}
+		written += n
+		if err != nil {
+			break
+		}
+	}
+	return len(data), err
+}
+
+func (c *Highlighter) WriteOneLine(line []byte) (int, error) {
+	N := len(line)
+
+	hasNewline := bytes.HasSuffix(line, []byte("\n"))
+	content := bytes.TrimSuffix(line, []byte("\n"))
+
+	rendered, ok := c.cacheGet(content)
+	if !ok {
+		rendered = c.renderLine(content)
+		c.cachePut(content, rendered)
+	}
+
+	written, err := c.out.Write(rendered)
+	if err != nil {
+		return written, err
+	}
+	if hasNewline {
+		_, err = c.out.Write([]byte("\n"))
+	}
+	return N, err
+}
+
+// renderLine applies the line and word rules to content and returns the
+// fully colored bytes (including the trailing reset), but never the
+// trailing newline -- that's handled separately so the cached rendering
+// doesn't depend on whether this happened to be the last line of input.
+func (c *Highlighter) renderLine(content []byte) []byte {
+	var buf bytes.Buffer
+	lineCol := c.pickLineColor(content)
+	if lineCol != "" {
+		buf.WriteString(lineCol)
+	} else {
+		lineCol = ansi.Reset // we should reset for each word if no line col
+	}
+	buf.Write(c.applyWordRules(content, lineCol))
+	if lineCol != ansi.Reset {
+		buf.WriteString(ansi.Reset)
+	}
+	return buf.Bytes()
+}
+
+func (c *Highlighter) cacheGet(content []byte) ([]byte, bool) {
+	if c.cache == nil {
+		return nil, false
+	}
+	return c.cache.Get(content)
+}
+
+func (c *Highlighter) cachePut(content, rendered []byte) {
+	if c.cache == nil {
+		return
+	}
+	c.cache.Put(content, rendered)
+}
+
+func (c *Highlighter) pickLineColor(line []byte) string {
+	for _, rule := range c.lineRules {
+		for _, pat := range rule.Patterns {
+			colorizeLine := pat.Match(line)
+			if rule.Inverse {
+				colorizeLine = !colorizeLine
+			}
+			if colorizeLine {
+				return rule.Color
+			}
+		}
+	}
+	return c.defaultColor
+}
+
+func (c *Highlighter) applyWordRules(line []byte, lineColor string) []byte {
+	const (
+		START = iota
+		STOP
+	)
+	type event struct {
+		typ   int // true if the color is starting, false if ending
+		color string
+		pos   int
+	}
+	var events []event
+
+	NUM_RULES := len(c.wordRules)
+	for i := range c.wordRules {
+		rule := c.wordRules[NUM_RULES-i-1]
+		for _, pat := range rule.Patterns {
+			for _, pos := range pat.FindAllIndex(line, -1) {
+				events = append(events,
+					event{START, rule.Color, pos[0]},
+					event{STOP, rule.Color, pos[1]})
+			}
+		}
+	}
+
+	// TokenRules produce their spans from a per-language lexer instead of a
+	// regex, but feed the same event/stack machinery as WordRules.
+	for _, rule := range c.tokenRules {
+		for _, tok := range rule.Lexer(line) {
+			color := rule.Colors[tok.Class]
+			if color == "" {
+				continue
+			}
+			events = append(events,
+				event{START, color, tok.Start},
+				event{STOP, color, tok.End})
+		}
+	}
+
+	if len(events) == 0 {
+		return line // no changes, no need to copy the line
+	}
+
+	// Sort the events by position. This will split up the start/stop events.
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].pos < events[j].pos
+	})
+
+	colorStack := []string{lineColor}
+	var lineOut []byte
+	cur := 0 // current position in the original line
+
+	for _, e := range events {
+		lineOut = append(lineOut, line[cur:e.pos]...)
+		color := e.color
+		if e.typ == START {
+			// Push e.color onto the color stack, it's now the latest color.
+			colorStack = append(colorStack, e.color)
+		} else {
+			// Pop e.color from the color stack.  It has to be on the stack somewhere,
+			// but if another overlapping pattern has been pushed in the meantime then
+			// it won't be the last item on the stack.  Since it's almost certainly
+			// vert recent and it's likely the color stack is very shallow, just do a
+			// reverse linear search through the stack looking for this color.
+			// In fact, since most cases won't be overlapping patterns, this loop will
+			// probably execute exactly one iteration.
+			N := len(colorStack)
+			var pos int
+			// Use pos > 0 because at worst we end up with pos = 0.
+			for pos = N - 1; pos > 0; pos-- {
+				if colorStack[pos] == e.color {
+					break
+				}
+			}
+			// When we find it, shift the stack down on top of it.  As mentioned
+			// earlier, pos will probably be the last entry of the stack and therefore
+			// this loop won't have any iterations.
+			for j := pos + 1; j < N; j++ {
+				colorStack[j-1] = colorStack[j]
+			}
+			// Shorten the stack.
+			colorStack = colorStack[:N-1]
+
+			tail := N - 2
+			color = colorStack[tail]
+		}
+		lineOut = append(lineOut, []byte(color)...)
+		cur = e.pos
+	}
+
+	// Copy whatever remains in the original line.
+	lineOut = append(lineOut, line[cur:]...)
+
+	return lineOut
+}
+
+// EscapingWriter writes p with all bytes escaped (as with strconv.Quote)
+// instead of interpreted, so that color codes show up as visible text
+// rather than being applied. Used by the CLI's --debug flag.
+type EscapingWriter struct{ Out io.Writer }
+
+func (e EscapingWriter) Write(p []byte) (int, error) {
+	newline := []byte("\n")
+	for _, line := range bytes.SplitAfter(p, newline) {
+		hasNewline := bytes.HasSuffix(line, newline)
+		if hasNewline {
+			line = line[:len(line)-1]
+		}
+		quoted := strconv.Quote(string(line))
+
+		// Strip the leading and trailing quotation marks: I want all
+		// the escaping, but not actually the quoting.
+		quoted = quoted[1 : len(quoted)-1]
+
+		io.WriteString(e.Out, quoted)
+		if hasNewline {
+			e.Out.Write(newline)
+		}
+	}
+	return len(p), nil
+}
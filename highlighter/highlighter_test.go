@@ -0,0 +1,104 @@
+package highlighter
+
+import (
+	"regexp"
+	"testing"
+)
+
+const reset = "\x1b[0m"
+
+func word(color string, patterns ...string) WordRule {
+	w := WordRule{Color: color}
+	for _, p := range patterns {
+		w.AddPattern(regexp.MustCompile(p))
+	}
+	return w
+}
+
+func TestApplyWordRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		wordRules []WordRule
+		line      string
+		lineColor string
+		want      string
+	}{
+		{
+			name:      "no rules, no changes",
+			line:      "hello world",
+			lineColor: reset,
+			want:      "hello world",
+		},
+		{
+			name:      "single match",
+			wordRules: []WordRule{word("RED", "world")},
+			line:      "hello world",
+			lineColor: reset,
+			want:      "hello RED" + "world" + reset,
+		},
+		{
+			name:      "empty match doesn't hang or panic",
+			wordRules: []WordRule{word("RED", "x*")},
+			line:      "abc",
+			lineColor: reset,
+			want:      "RED" + reset + "aRED" + reset + "bRED" + reset + "cRED" + reset,
+		},
+		{
+			name:      "adjacent non-overlapping matches",
+			wordRules: []WordRule{word("RED", "foo"), word("BLUE", "bar")},
+			line:      "foobar",
+			lineColor: reset,
+			want:      "RED" + "foo" + "BLUE" + "BLUE" + "bar" + reset,
+		},
+		{
+			name: "nested matches: inner rule takes precedence, outer resumes after",
+			// rules are applied with the *first* rule taking precedence, and
+			// WordRules are pushed onto the color stack in reverse so that
+			// happens; "RED" is rule[0] (outer), "BLUE" is rule[1] (inner).
+			wordRules: []WordRule{word("RED", "a.*e"), word("BLUE", "bcd")},
+			line:      "abcde",
+			lineColor: reset,
+			want:      "RED" + "a" + "BLUE" + "bcd" + "RED" + "e" + reset,
+		},
+		{
+			name:      "overlapping matches from the same rule",
+			wordRules: []WordRule{word("RED", "ab", "bc")},
+			line:      "abc",
+			lineColor: reset,
+			want:      "RED" + "a" + "RED" + "b" + "RED" + "c" + reset,
+		},
+		{
+			name:      "unicode boundaries are respected",
+			wordRules: []WordRule{word("RED", "世界")},
+			line:      "hello 世界 !",
+			lineColor: reset,
+			want:      "hello RED" + "世界" + reset + " !",
+		},
+		{
+			name:      "line color is restored when a word match ends",
+			wordRules: []WordRule{word("RED", "world")},
+			line:      "hello world",
+			lineColor: "GREEN",
+			want:      "hello RED" + "world" + "GREEN",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Highlighter{wordRules: tc.wordRules}
+			got := string(h.applyWordRules([]byte(tc.line), tc.lineColor))
+			if got != tc.want {
+				t.Errorf("applyWordRules(%q) = %q, want %q", tc.line, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestApplyWordRulesNoMatchesReturnsSameSlice(t *testing.T) {
+	h := &Highlighter{wordRules: []WordRule{word("RED", "xyz")}}
+	line := []byte("abc")
+	out := h.applyWordRules(line, reset)
+	if &out[0] != &line[0] {
+		t.Error("applyWordRules should return the original slice when nothing matches")
+	}
+}
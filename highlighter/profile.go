@@ -0,0 +1,209 @@
+package highlighter
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Profile is a named set of highlight rules loaded from a syntax file,
+// analogous to the "color <color> <regex>..." files used by nano/micro.
+//
+// A profile file consists of one directive per line:
+//
+//	color <color> word <regex...>
+//	color <color> line <regex...>
+//	color <color> line-inverse <regex...>
+//
+// Blank lines and lines starting with '#' are ignored.  Two additional
+// directives control auto-selection when a profile isn't requested
+// explicitly:
+//
+//	glob <pattern>     # select this profile when the input filename matches
+//	firstline <regex>  # select this profile when the first line of input matches
+//
+// A profile may have any number of glob/firstline directives.
+type Profile struct {
+	Name       string
+	Globs      []string
+	FirstLines []*regexp.Regexp
+	Entries    []ProfileEntry
+}
+
+// ProfileEntry is a single "color <color> <mode> <regex...>" directive.
+type ProfileEntry struct {
+	Mode     string // "word", "line", or "line-inverse"
+	Color    string
+	Patterns []string
+}
+
+// ProfileSearchPath returns the directories searched for named profiles
+// (e.g. `highlight --profile go`), in priority order:
+// $XDG_CONFIG_HOME/highlight (or ~/.config/highlight), then
+// /etc/highlight.
+func ProfileSearchPath() []string {
+	var dirs []string
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		dirs = append(dirs, filepath.Join(xdg, "highlight"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".config", "highlight"))
+	}
+	dirs = append(dirs, "/etc/highlight")
+	return dirs
+}
+
+// FindProfile locates a named profile (without its .hl extension) on the
+// search path and returns the path to its file.
+func FindProfile(name string) (string, error) {
+	for _, dir := range ProfileSearchPath() {
+		path := filepath.Join(dir, name+".hl")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no such profile %q on search path %v", name, ProfileSearchPath())
+}
+
+// LoadProfile parses the profile file at path.
+func LoadProfile(path string) (*Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &Profile{Name: strings.TrimSuffix(filepath.Base(path), ".hl")}
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "glob":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("%s:%d: glob requires exactly one pattern", path, lineNum)
+			}
+			p.Globs = append(p.Globs, fields[1])
+		case "firstline":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("%s:%d: firstline requires a regex", path, lineNum)
+			}
+			re, err := regexp.Compile(strings.Join(fields[1:], " "))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: bad firstline regex: %v", path, lineNum, err)
+			}
+			p.FirstLines = append(p.FirstLines, re)
+		case "color":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("%s:%d: expected \"color <color> <mode> <regex...>\"", path, lineNum)
+			}
+			mode := fields[2]
+			if mode != "word" && mode != "line" && mode != "line-inverse" {
+				return nil, fmt.Errorf("%s:%d: unknown mode %q", path, lineNum, mode)
+			}
+			p.Entries = append(p.Entries, ProfileEntry{
+				Mode:     mode,
+				Color:    fields[1],
+				Patterns: fields[3:],
+			})
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown directive %q", path, lineNum, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Matches reports whether the profile should be auto-selected for a file
+// with the given name and (optionally empty) first line of content.
+func (p *Profile) Matches(filename string, firstLine []byte) bool {
+	base := filepath.Base(filename)
+	for _, glob := range p.Globs {
+		if ok, _ := filepath.Match(glob, base); ok {
+			return true
+		}
+	}
+	for _, re := range p.FirstLines {
+		if re.Match(firstLine) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectProfile returns the first loaded profile matching filename or
+// firstLine, or nil if none match.
+func SelectProfile(profiles []*Profile, filename string, firstLine []byte) *Profile {
+	for _, p := range profiles {
+		if p.Matches(filename, firstLine) {
+			return p
+		}
+	}
+	return nil
+}
+
+// LoadProfiles loads every profile found on the search path (see
+// ProfileSearchPath), for auto-selection via SelectProfile when the user
+// hasn't named a profile explicitly. Search directories that don't exist
+// (or aren't readable) are skipped rather than treated as an error; when
+// the same profile name appears in more than one directory, the one
+// earlier in the search path wins.
+func LoadProfiles() ([]*Profile, error) {
+	seen := map[string]bool{}
+	var profiles []*Profile
+	for _, dir := range ProfileSearchPath() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), ".hl") {
+				continue
+			}
+			name := strings.TrimSuffix(e.Name(), ".hl")
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			p, err := LoadProfile(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			profiles = append(profiles, p)
+		}
+	}
+	return profiles, nil
+}
+
+// Apply compiles the profile's entries and adds them to cfg as word/line
+// rules, in file order.
+func (p *Profile) Apply(cfg *Config) error {
+	for _, e := range p.Entries {
+		color := ParseColor(e.Color)
+		var rule Rule
+		switch e.Mode {
+		case "word":
+			rule = &WordRule{Color: color}
+		case "line":
+			rule = &LineRule{Color: color}
+		case "line-inverse":
+			rule = &LineRule{Color: color, Inverse: true}
+		}
+		for _, pat := range e.Patterns {
+			re, err := regexp.Compile(pat)
+			if err != nil {
+				return fmt.Errorf("profile %s: bad pattern %q: %v", p.Name, pat, err)
+			}
+			rule.AddPattern(re)
+		}
+		cfg.AddRule(rule)
+	}
+	return nil
+}
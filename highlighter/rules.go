@@ -0,0 +1,35 @@
+// Package highlighter implements the colorizing engine behind the
+// highlight CLI: a stream of text goes in, and comes out annotated with
+// ANSI color codes according to a set of word/line/token rules.
+//
+// The CLI in package main is a thin wrapper around this package, so other
+// Go programs can wrap an io.Writer with the same logic without shelling
+// out to the highlight binary.
+package highlighter
+
+import "regexp"
+
+// Rule is a single highlighting rule: a set of regex patterns and what to
+// do when one matches.  WordRule and LineRule are the two built-in kinds;
+// TokenRule colorizes by lexer-produced spans instead of a regex.
+type Rule interface {
+	AddPattern(*regexp.Regexp)
+}
+
+// WordRule colorizes the matched portion of any of its Patterns,
+// wherever they occur within a line.
+type WordRule struct {
+	Color    string
+	Patterns []*regexp.Regexp
+}
+
+// LineRule colorizes an entire line when (or, if Inverse, when NOT) any
+// of its Patterns match.
+type LineRule struct {
+	Inverse  bool
+	Color    string
+	Patterns []*regexp.Regexp
+}
+
+func (w *WordRule) AddPattern(pattern *regexp.Regexp) { w.Patterns = append(w.Patterns, pattern) }
+func (l *LineRule) AddPattern(pattern *regexp.Regexp) { l.Patterns = append(l.Patterns, pattern) }
@@ -0,0 +1,210 @@
+package highlighter
+
+import (
+	"fmt"
+	"regexp"
+	"unicode"
+)
+
+// TokenClass identifies the lexical category of a token produced by a
+// LineLexer: keyword, string literal, number, comment, etc.
+type TokenClass string
+
+const (
+	ClassKeyword TokenClass = "keyword"
+	ClassString  TokenClass = "string"
+	ClassNumber  TokenClass = "number"
+	ClassComment TokenClass = "comment"
+)
+
+// Token is a single (offset, length, class) span produced by a LineLexer.
+type Token struct {
+	Start, End int
+	Class      TokenClass
+}
+
+// LineLexer tokenizes a single line of input into classified spans.  Unlike
+// WordRule patterns, a LineLexer doesn't need regexes: it's a small
+// hand-written scanner per language.
+type LineLexer func(line []byte) []Token
+
+// LexerByName returns the built-in lexer for the named language/format, or
+// an error if there isn't one.
+func LexerByName(name string) (LineLexer, error) {
+	switch name {
+	case "go":
+		return lexGo, nil
+	case "json":
+		return lexJSON, nil
+	case "log":
+		return lexLog, nil
+	}
+	return nil, fmt.Errorf("no built-in tokenizer for %q (have: go, json, log)", name)
+}
+
+// TokenRule colorizes a line by lexical token class rather than a single
+// regex, using a per-language LineLexer.  It's consumed by applyWordRules
+// the same way a WordRule is: the lexer's tokens are turned into
+// START/STOP color events over the matched spans.
+type TokenRule struct {
+	Lexer  LineLexer
+	Colors map[TokenClass]string
+}
+
+// AddPattern exists so TokenRule satisfies Rule; it's a no-op because a
+// TokenRule's spans come from its Lexer, not from explicit patterns.
+func (t *TokenRule) AddPattern(*regexp.Regexp) {}
+
+var goKeywords = map[string]bool{
+	"break": true, "default": true, "func": true, "interface": true, "select": true,
+	"case": true, "defer": true, "go": true, "map": true, "struct": true,
+	"chan": true, "else": true, "goto": true, "package": true, "switch": true,
+	"const": true, "fallthrough": true, "if": true, "range": true, "type": true,
+	"continue": true, "for": true, "import": true, "return": true, "var": true,
+}
+
+// lexGo is a small, approximate Go tokenizer: enough to classify keywords,
+// string/rune literals, numbers, and line/block comments for highlighting
+// purposes.  It isn't a full Go scanner.
+func lexGo(line []byte) []Token {
+	var toks []Token
+	i := 0
+	n := len(line)
+	for i < n {
+		c := line[i]
+		switch {
+		case c == '/' && i+1 < n && line[i+1] == '/':
+			toks = append(toks, Token{i, n, ClassComment})
+			i = n
+		case c == '/' && i+1 < n && line[i+1] == '*':
+			start := i
+			i += 2
+			for i < n && !(line[i] == '*' && i+1 < n && line[i+1] == '/') {
+				i++
+			}
+			if i < n {
+				i += 2
+			}
+			toks = append(toks, Token{start, i, ClassComment})
+		case c == '"' || c == '`' || c == '\'':
+			start := i
+			quote := c
+			i++
+			for i < n && line[i] != quote {
+				if quote != '`' && line[i] == '\\' && i+1 < n {
+					i++
+				}
+				i++
+			}
+			if i < n {
+				i++
+			}
+			toks = append(toks, Token{start, i, ClassString})
+		case unicode.IsDigit(rune(c)):
+			start := i
+			for i < n && (unicode.IsDigit(rune(line[i])) || line[i] == '.' || line[i] == 'x' ||
+				(line[i] >= 'a' && line[i] <= 'f') || (line[i] >= 'A' && line[i] <= 'F')) {
+				i++
+			}
+			toks = append(toks, Token{start, i, ClassNumber})
+		case unicode.IsLetter(rune(c)) || c == '_':
+			start := i
+			for i < n && (unicode.IsLetter(rune(line[i])) || unicode.IsDigit(rune(line[i])) || line[i] == '_') {
+				i++
+			}
+			if word := string(line[start:i]); goKeywords[word] {
+				toks = append(toks, Token{start, i, ClassKeyword})
+			}
+		default:
+			i++
+		}
+	}
+	return toks
+}
+
+// lexJSON classifies JSON string literals (including object keys) and
+// numbers.  true/false/null are highlighted as keywords.
+func lexJSON(line []byte) []Token {
+	var toks []Token
+	i := 0
+	n := len(line)
+	for i < n {
+		c := line[i]
+		switch {
+		case c == '"':
+			start := i
+			i++
+			for i < n && line[i] != '"' {
+				if line[i] == '\\' && i+1 < n {
+					i++
+				}
+				i++
+			}
+			if i < n {
+				i++
+			}
+			toks = append(toks, Token{start, i, ClassString})
+		case c == '-' || unicode.IsDigit(rune(c)):
+			start := i
+			i++
+			for i < n && (unicode.IsDigit(rune(line[i])) || line[i] == '.' || line[i] == 'e' || line[i] == 'E' || line[i] == '+' || line[i] == '-') {
+				i++
+			}
+			toks = append(toks, Token{start, i, ClassNumber})
+		case unicode.IsLetter(rune(c)):
+			start := i
+			for i < n && unicode.IsLetter(rune(line[i])) {
+				i++
+			}
+			switch string(line[start:i]) {
+			case "true", "false", "null":
+				toks = append(toks, Token{start, i, ClassKeyword})
+			}
+		default:
+			i++
+		}
+	}
+	return toks
+}
+
+// lexLog recognizes a leading syslog-style timestamp (treated as a
+// comment, since it's rarely what you want highlighted) and common
+// severity keywords anywhere on the line.  Go's log package timestamps
+// ("2006/01/02 15:04:05") are not recognized as timestamps.
+func lexLog(line []byte) []Token {
+	var toks []Token
+	if len(line) >= 15 && isLogTimestamp(line[:15]) {
+		toks = append(toks, Token{0, 15, ClassComment})
+	}
+	severities := []string{"DEBUG", "INFO", "WARN", "WARNING", "ERROR", "FATAL", "PANIC"}
+	i := 0
+	n := len(line)
+	for i < n {
+		if !unicode.IsLetter(rune(line[i])) {
+			i++
+			continue
+		}
+		start := i
+		for i < n && unicode.IsLetter(rune(line[i])) {
+			i++
+		}
+		word := string(line[start:i])
+		for _, sev := range severities {
+			if word == sev {
+				toks = append(toks, Token{start, i, ClassKeyword})
+				break
+			}
+		}
+	}
+	return toks
+}
+
+// isLogTimestamp checks for the syslog "Mmm dd hh:mm:ss"-shaped prefix,
+// e.g. "Jan  2 15:04:05".
+func isLogTimestamp(s []byte) bool {
+	if len(s) < 15 {
+		return false
+	}
+	return unicode.IsUpper(rune(s[0])) && unicode.IsLower(rune(s[1])) && unicode.IsLower(rune(s[2])) && s[3] == ' ' &&
+		s[6] == ' ' && s[9] == ':' && s[12] == ':'
+}
@@ -1,17 +1,18 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"io"
 	"log"
 	"os"
 	"regexp"
-	"sort"
-	"strconv"
 	"strings"
 
 	"github.com/augustoroman/ansi" // change back to "github.com/mgutz/ansi" when PR is accepted
 	"github.com/fluxio/iohelpers/line"
+
+	"github.com/augustoroman/highlight/highlighter"
 )
 
 var usage = `
@@ -34,6 +35,35 @@ In addition, the following configuration options are independent of patterns:
   -c <color>   Set the default color for all unmatched text.  If specified
                multiple times, the last one takes precedence.
 
+  -f <file>        Load word/line rules from a profile file instead of (or
+                    in addition to) specifying patterns on the command line.
+  --profile <name>  Load the named profile from the search path
+                    ($XDG_CONFIG_HOME/highlight, /etc/highlight).  Both -f
+                    and --profile may be given multiple times to combine
+                    several profiles on one invocation.
+
+  -in <file>       Read input from <file> instead of stdin.  Unless -f or
+                    --profile is also given, the profiles on the search
+                    path are scanned and the first one whose "glob" matches
+                    <file>'s name or whose "firstline" matches <file>'s
+                    first line is applied automatically.
+
+  -tokens <lang>   Colorize by lexical token class using a built-in
+                   tokenizer for <lang> (one of: go, json, log) instead of
+                   a regex.  Followed by any of:
+                     -keyword <color>  -string <color>
+                     -number <color>   -comment <color>
+                   to set the color for each token class that lang produces.
+
+  -diff            Treat the input as a unified diff: colorize file headers,
+                   "@@" hunk headers, and +/- lines, with intra-line
+                   word-diff highlighting of the changed region within each
+                   adjacent -/+ pair.  Colors may be overridden with:
+                     -add <color>   -del <color>   -hunk <color>
+
+  --nocache    Disable the per-line rendered-output cache.
+  --cachestats Print cache hit/miss counts to stderr on exit.
+
   --debug      Escape all output, no colors are printed but color codes are
                visible.
 
@@ -46,6 +76,13 @@ Colors:
     black  red  green  yellow  blue  magenta  cyan  white  default
   Or via the 256 color palette number:
     0 1 2 ...
+  Or as truecolor (24-bit RGB), any of:
+    #rrggbb        e.g. #ff8800
+    rgb(r,g,b)     e.g. rgb(255,136,0)
+    a CSS color name, e.g. orange, slategray
+  Truecolor values are emitted as 24-bit escapes when $COLORTERM is
+  "truecolor" or "24bit", and otherwise downgraded to the nearest
+  xterm-256 palette color.
 
   Modifiers may be combinations of:
     d = dim
@@ -100,11 +137,15 @@ func main() {
 	var DefaultWordHighlightColor = ansi.LightBlue
 	log.SetFlags(0)
 
-	colorizer := &ColorizerWriter{Out: os.Stdout}
+	out := io.Writer(os.Stdout)
+	var cfg highlighter.Config
+	cacheStats := false
+	inputPath := ""
+	explicitProfile := false
 
 	// The current rule as we are parsing the command-line.  This may be either a
-	// WordRule or a LineRule.
-	var current Rule
+	// WordRule, LineRule, or TokenRule.
+	var current highlighter.Rule
 
 	for i := 1; i < len(os.Args); i++ {
 		arg := os.Args[i]
@@ -115,7 +156,85 @@ func main() {
 				log.Println(usage)
 				os.Exit(0)
 			} else if mode == "debug" {
-				colorizer.Out = EscapingWriter{os.Stdout}
+				out = highlighter.EscapingWriter{Out: os.Stdout}
+				continue
+			} else if mode == "nocache" {
+				cfg.NoCache = true
+				continue
+			} else if mode == "cachestats" {
+				cacheStats = true
+				continue
+			} else if mode == "f" || mode == "profile" {
+				i++
+				if i == len(os.Args) {
+					break
+				}
+				path := os.Args[i]
+				if mode == "profile" {
+					var err error
+					path, err = highlighter.FindProfile(os.Args[i])
+					if err != nil {
+						log.Fatalf("%s\n%sERROR: %v", usage, ansi.Red, err)
+					}
+				}
+				profile, err := highlighter.LoadProfile(path)
+				if err != nil {
+					log.Fatalf("%s\n%sERROR: Bad profile %q: %v",
+						usage, ansi.Red, os.Args[i], err)
+				}
+				cfg.AddRule(current)
+				current = nil
+				explicitProfile = true
+				if err := profile.Apply(&cfg); err != nil {
+					log.Fatalf("%s\n%sERROR: %v", usage, ansi.Red, err)
+				}
+				continue
+			} else if mode == "in" {
+				i++
+				if i == len(os.Args) {
+					break
+				}
+				inputPath = os.Args[i]
+				continue
+			} else if mode == "diff" {
+				cfg.Diff = &highlighter.DiffConfig{
+					FileHeaderColor: ansi.White,
+					HunkColor:       highlighter.ParseColor("cyan"),
+					AddColor:        ansi.Green,
+					DelColor:        ansi.Red,
+					AddWordColor:    highlighter.ParseColor("green+b"),
+					DelWordColor:    highlighter.ParseColor("red+b"),
+				}
+				continue
+			} else if mode == "add" || mode == "del" || mode == "hunk" {
+				i++
+				if i == len(os.Args) {
+					break
+				}
+				if cfg.Diff == nil {
+					log.Fatalf("%s\n%sERROR: -%s requires -diff", usage, ansi.Red, mode)
+				}
+				color := highlighter.ParseColor(os.Args[i])
+				switch mode {
+				case "add":
+					cfg.Diff.AddColor = color
+				case "del":
+					cfg.Diff.DelColor = color
+				case "hunk":
+					cfg.Diff.HunkColor = color
+				}
+				continue
+			} else if mode == "tokens" {
+				i++
+				if i == len(os.Args) {
+					break
+				}
+				lexer, err := highlighter.LexerByName(os.Args[i])
+				if err != nil {
+					log.Fatalf("%s\n%sERROR: %v", usage, ansi.Red, err)
+				}
+				cfg.AddRule(current)
+				current = &highlighter.TokenRule{Lexer: lexer, Colors: map[highlighter.TokenClass]string{}}
 				continue
 			}
 
@@ -123,27 +242,33 @@ func main() {
 			if i == len(os.Args) {
 				break
 			}
-			color := ansi.ColorCode(os.Args[i])
+			color := highlighter.ParseColor(os.Args[i])
 
 			switch mode {
 			case "l":
-				colorizer.AddRuleIfNotNil(current)
-				current = &LineRule{Color: color}
+				cfg.AddRule(current)
+				current = &highlighter.LineRule{Color: color}
 			case "lx":
-				colorizer.AddRuleIfNotNil(current)
-				current = &LineRule{Color: color, Inverse: true}
+				cfg.AddRule(current)
+				current = &highlighter.LineRule{Color: color, Inverse: true}
 			case "w":
-				colorizer.AddRuleIfNotNil(current)
-				current = &WordRule{Color: color}
+				cfg.AddRule(current)
+				current = &highlighter.WordRule{Color: color}
 			case "c":
-				colorizer.DefaultColor = color
+				cfg.DefaultColor = color
+			case "keyword", "string", "number", "comment":
+				tok, ok := current.(*highlighter.TokenRule)
+				if !ok {
+					log.Fatalf("%s\n%sERROR: -%s must follow -tokens <language>", usage, ansi.Red, mode)
+				}
+				tok.Colors[highlighter.TokenClass(mode)] = color
 			default:
 				log.Fatalf("%s\n%sERROR: No such mode: %q",
 					usage, ansi.Red, mode)
 			}
 		} else {
 			if current == nil {
-				current = &WordRule{Color: DefaultWordHighlightColor}
+				current = &highlighter.WordRule{Color: DefaultWordHighlightColor}
 			}
 			pattern, err := regexp.Compile(arg)
 			if err != nil {
@@ -153,216 +278,53 @@ func main() {
 			current.AddPattern(pattern)
 		}
 	}
-	colorizer.AddRuleIfNotNil(current)
-
-	// BoundaryWriter allows us to ensure that we don't write parts of lines.
-	out := &line.BoundaryWriter{Target: colorizer}
-	_, err := io.Copy(out, os.Stdin)
-	if err != nil {
-		log.Fatal(ansi.Red + err.Error())
-	}
-}
-
-type EscapingWriter struct{ Out io.Writer }
-
-func (e EscapingWriter) Write(p []byte) (int, error) {
-	newline := []byte("\n")
-	for _, line := range bytes.SplitAfter(p, newline) {
-		hasNewline := bytes.HasSuffix(line, newline)
-		if hasNewline {
-			line = line[:len(line)-1]
-		}
-		quoted := strconv.Quote(string(line))
-
-		// Strip the leading and trailing quotation marks: I want all
-		// the escaping, but not actually the quoting.
-		quoted = quoted[1 : len(quoted)-1]
-
-		io.WriteString(e.Out, quoted)
-		if hasNewline {
-			e.Out.Write(newline)
-		}
-	}
-	return len(p), nil
-}
-
-type Rule interface {
-	AddPattern(*regexp.Regexp)
-}
-
-type WordRule struct {
-	Color    string
-	Patterns []*regexp.Regexp
-}
-type LineRule struct {
-	Inverse  bool
-	Color    string
-	Patterns []*regexp.Regexp
-}
-
-func (w *WordRule) AddPattern(pattern *regexp.Regexp) { w.Patterns = append(w.Patterns, pattern) }
-func (l *LineRule) AddPattern(pattern *regexp.Regexp) { l.Patterns = append(l.Patterns, pattern) }
-
-type ColorizerWriter struct {
-	DefaultColor string
-	WordRules    []WordRule
-	LineRules    []LineRule
-	Out          io.Writer
-}
-
-func (c *ColorizerWriter) AddRuleIfNotNil(rule interface{}) {
-	if rule == nil {
-		return
-	}
-	switch r := rule.(type) {
-	case *LineRule:
-		c.LineRules = append(c.LineRules, *r)
-	case *WordRule:
-		c.WordRules = append(c.WordRules, *r)
-	default:
-		log.Fatalf("Unknown rule type: %T", rule)
-	}
-}
-
-func (c *ColorizerWriter) Write(data []byte) (int, error) {
-	var err error
-	var n, written int
-	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
-		n, err = c.WriteOneLine(line)
-		written += n
-		if err != nil {
-			break
-		}
-	}
-	return len(data), err
-}
-
-func (c *ColorizerWriter) WriteOneLine(line []byte) (int, error) {
-	N := len(line)
-	written := 0
+	cfg.AddRule(current)
 
-	hasNewline := bytes.HasSuffix(line, []byte("\n"))
-	line = bytes.TrimSuffix(line, []byte("\n"))
-	lineCol := c.pickLineColor(line)
-	if lineCol != "" {
-		n, err := c.Out.Write([]byte(lineCol))
+	in := io.Reader(os.Stdin)
+	if inputPath != "" {
+		f, err := os.Open(inputPath)
 		if err != nil {
-			return n, err
+			log.Fatal(ansi.Red + err.Error())
 		}
-		written += n
-	} else {
-		lineCol = ansi.Reset // we should reset for each word if no line col
-	}
-	line = c.applyWordRules(line, lineCol)
+		defer f.Close()
 
-	n, err := c.Out.Write(line)
-	if err != nil {
-		return n + written, err
-	}
-	written += n
+		// Sniff the first line for profile auto-selection, then put it
+		// back so it's still part of the highlighted output.
+		r := bufio.NewReader(f)
+		firstLine, _ := r.ReadBytes('\n')
+		in = io.MultiReader(bytes.NewReader(firstLine), r)
 
-	if lineCol != ansi.Reset {
-		n, err = c.Out.Write([]byte(ansi.Reset))
-		written += n
-	}
-	if hasNewline {
-		_, err = c.Out.Write([]byte("\n"))
-	}
-	return N, err
-}
-
-func (c *ColorizerWriter) pickLineColor(line []byte) string {
-	for _, rule := range c.LineRules {
-		for _, pat := range rule.Patterns {
-			colorizeLine := pat.Match(line)
-			if rule.Inverse {
-				colorizeLine = !colorizeLine
+		if !explicitProfile {
+			profiles, err := highlighter.LoadProfiles()
+			if err != nil {
+				log.Fatal(ansi.Red + err.Error())
 			}
-			if colorizeLine {
-				return rule.Color
+			if p := highlighter.SelectProfile(profiles, inputPath, firstLine); p != nil {
+				if err := p.Apply(&cfg); err != nil {
+					log.Fatalf("%s\n%sERROR: %v", usage, ansi.Red, err)
+				}
 			}
 		}
 	}
-	return c.DefaultColor
-}
 
-func (c *ColorizerWriter) applyWordRules(line []byte, lineColor string) []byte {
-	const (
-		START = iota
-		STOP
-	)
-	type event struct {
-		typ   int // true if the color is starting, false if ending
-		color string
-		pos   int
+	colorizer := highlighter.NewHighlighter(out, cfg)
+
+	// BoundaryWriter allows us to ensure that we don't write parts of lines.
+	boundary := &line.BoundaryWriter{Target: colorizer}
+	_, err := io.Copy(boundary, in)
+	if err != nil {
+		log.Fatal(ansi.Red + err.Error())
 	}
-	var events []event
-
-	NUM_RULES := len(c.WordRules)
-	for i := range c.WordRules {
-		rule := c.WordRules[NUM_RULES-i-1]
-		for _, pat := range rule.Patterns {
-			for _, pos := range pat.FindAllIndex(line, -1) {
-				events = append(events,
-					event{START, rule.Color, pos[0]},
-					event{STOP, rule.Color, pos[1]})
-			}
+	if closer, ok := colorizer.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Fatal(ansi.Red + err.Error())
 		}
 	}
-
-	if len(events) == 0 {
-		return line // no changes, no need to copy the line
-	}
-
-	// Sort the events by position. This will split up the start/stop events.
-	sort.SliceStable(events, func(i, j int) bool {
-		return events[i].pos < events[j].pos
-	})
-
-	colorStack := []string{lineColor}
-	var lineOut []byte
-	cur := 0 // current position in the original line
-
-	for _, e := range events {
-		lineOut = append(lineOut, line[cur:e.pos]...)
-		color := e.color
-		if e.typ == START {
-			// Push e.color onto the color stack, it's now the latest color.
-			colorStack = append(colorStack, e.color)
-		} else {
-			// Pop e.color from the color stack.  It has to be on the stack somewhere,
-			// but if another overlapping pattern has been pushed in the meantime then
-			// it won't be the last item on the stack.  Since it's almost certainly
-			// vert recent and it's likely the color stack is very shallow, just do a
-			// reverse linear search through the stack looking for this color.
-			// In fact, since most cases won't be overlapping patterns, this loop will
-			// probably execute exactly one iteration.
-			N := len(colorStack)
-			var pos int
-			// Use pos > 0 because at worst we end up with pos = 0.
-			for pos = N - 1; pos > 0; pos-- {
-				if colorStack[pos] == e.color {
-					break
-				}
-			}
-			// When we find it, shift the stack down on top of it.  As mentioned
-			// earlier, pos will probably be the last entry of the stack and therefore
-			// this loop won't have any iterations.
-			for j := pos + 1; j < N; j++ {
-				colorStack[j-1] = colorStack[j]
+	if cacheStats {
+		if stats, ok := colorizer.(interface{ CacheStats() string }); ok {
+			if s := stats.CacheStats(); s != "" {
+				log.Print(s)
 			}
-			// Shorten the stack.
-			colorStack = colorStack[:N-1]
-
-			tail := N - 2
-			color = colorStack[tail]
 		}
-		lineOut = append(lineOut, []byte(color)...)
-		cur = e.pos
 	}
-
-	// Copy whatever remains in the original line.
-	lineOut = append(lineOut, line[cur:]...)
-
-	return lineOut
 }